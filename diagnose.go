@@ -0,0 +1,229 @@
+package go_ipfs_p2p
+
+import (
+	"context"
+	"fmt"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/routing"
+	"github.com/libp2p/go-libp2p-core/transport"
+	ma "github.com/multiformats/go-multiaddr"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diagnoseDialTimeout bounds every single dial attempt made by DiagnosePeer
+var diagnoseDialTimeout = 10 * time.Second
+
+// ConnectionDiagnosis describes an open connection to the diagnosed peer
+type ConnectionDiagnosis struct {
+	RemoteAddr string
+	Transport  string
+	Direction  string
+	Relayed    bool
+}
+
+// AddressDiagnosis is the result of dialing one known address of the diagnosed peer
+type AddressDiagnosis struct {
+	Addr      string
+	Transport string
+	Dialable  bool
+	Latency   time.Duration
+	Error     string
+}
+
+// RelayDiagnosis is the result of opening a circuit to the diagnosed peer through one relay
+type RelayDiagnosis struct {
+	Relay    string
+	Dialable bool
+	Error    string
+}
+
+// DHTQueryStep is one event observed while looking the peer up in the DHT
+type DHTQueryStep struct {
+	Time  time.Time
+	Type  string
+	Peer  string
+	Extra string
+}
+
+// PeerDiagnosis explains how (and whether) a peer can be reached
+type PeerDiagnosis struct {
+	PeerId      string
+	Connected   bool
+	Connections []ConnectionDiagnosis
+	Addresses   []AddressDiagnosis
+	Relays      []RelayDiagnosis
+	// RelayPath is the first relay through which a circuit could be opened, empty if none
+	RelayPath string
+	DHTFound  bool
+	DHTAddrs  []string
+	DHTError  string
+	DHTTrace  []DHTQueryStep
+}
+
+// transportDialer is implemented by the swarm and gives access to the transport for an address
+type transportDialer interface {
+	TransportForDialing(a ma.Multiaddr) transport.Transport
+}
+
+// DiagnosePeer reports every known address of a peer, which of them can be dialed,
+// whether a relay path exists and how the DHT lookup for the peer went
+func (c *P2pClient) DiagnosePeer(ctx context.Context, peerId string) (*PeerDiagnosis, error) {
+	id, err := peer.Decode(peerId)
+	if err != nil {
+		return nil, err
+	}
+	report := &PeerDiagnosis{PeerId: peerId}
+
+	// look the peer up first, the lookup adds fresh addresses to the peerstore
+	c.diagnoseDHT(ctx, id, report)
+
+	for _, conn := range c.Host.Network().ConnsToPeer(id) {
+		addr := conn.RemoteMultiaddr()
+		report.Connections = append(report.Connections, ConnectionDiagnosis{
+			RemoteAddr: addr.String(),
+			Transport:  transportName(addr),
+			Direction:  conn.Stat().Direction.String(),
+			Relayed:    isRelayAddr(addr),
+		})
+	}
+	report.Connected = c.Host.Network().Connectedness(id) == network.Connected
+
+	addrs := c.Host.Peerstore().Addrs(id)
+	report.Addresses = make([]AddressDiagnosis, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(i int, addr ma.Multiaddr) {
+			defer wg.Done()
+			report.Addresses[i] = c.diagnoseAddr(ctx, id, addr)
+		}(i, addr)
+	}
+	wg.Wait()
+
+	for _, relay := range convertPeers(c.Peers) {
+		if relay.ID == id {
+			continue
+		}
+		circuit := ma.StringCast(fmt.Sprintf("/p2p/%s/p2p-circuit", relay.ID.Pretty()))
+		res := c.diagnoseAddr(ctx, id, circuit)
+		report.Relays = append(report.Relays, RelayDiagnosis{
+			Relay:    relay.ID.Pretty(),
+			Dialable: res.Dialable,
+			Error:    res.Error,
+		})
+		if res.Dialable && report.RelayPath == "" {
+			report.RelayPath = relay.ID.Pretty()
+		}
+	}
+
+	return report, nil
+}
+
+func (c *P2pClient) diagnoseDHT(ctx context.Context, id peer.ID, report *PeerDiagnosis) {
+	qctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	qctx, events := routing.RegisterForQueryEvents(qctx)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for e := range events {
+			report.DHTTrace = append(report.DHTTrace, DHTQueryStep{
+				Time:  time.Now(),
+				Type:  queryEventName(e.Type),
+				Peer:  e.ID.Pretty(),
+				Extra: e.Extra,
+			})
+		}
+	}()
+
+	info, err := c.DHT.FindPeer(qctx, id)
+	cancel()
+	<-done
+
+	if err != nil {
+		report.DHTError = err.Error()
+		return
+	}
+	report.DHTFound = true
+	for _, addr := range info.Addrs {
+		report.DHTAddrs = append(report.DHTAddrs, addr.String())
+	}
+}
+
+// diagnoseAddr dials addr with the matching transport directly, bypassing the swarm so an
+// existing connection to the peer does not hide the result
+func (c *P2pClient) diagnoseAddr(ctx context.Context, id peer.ID, addr ma.Multiaddr) AddressDiagnosis {
+	res := AddressDiagnosis{
+		Addr:      addr.String(),
+		Transport: transportName(addr),
+	}
+
+	td, ok := c.Host.Network().(transportDialer)
+	if !ok {
+		res.Error = "network does not expose its transports"
+		return res
+	}
+	tpt := td.TransportForDialing(addr)
+	if tpt == nil {
+		res.Error = "no transport for address"
+		return res
+	}
+
+	dctx, cancel := context.WithTimeout(ctx, diagnoseDialTimeout)
+	defer cancel()
+	start := time.Now()
+	conn, err := tpt.Dial(dctx, addr, id)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.Latency = time.Since(start)
+	res.Dialable = true
+	_ = conn.Close()
+	return res
+}
+
+// transportName returns the transport protocols of addr, e.g. "tcp" or "tcp/ws"
+func transportName(addr ma.Multiaddr) string {
+	var names []string
+	ma.ForEach(addr, func(c ma.Component) bool {
+		switch c.Protocol().Code {
+		case ma.P_IP4, ma.P_IP6, ma.P_DNS, ma.P_DNS4, ma.P_DNS6, ma.P_DNSADDR, ma.P_P2P:
+		default:
+			names = append(names, c.Protocol().Name)
+		}
+		return true
+	})
+	return strings.Join(names, "/")
+}
+
+func isRelayAddr(addr ma.Multiaddr) bool {
+	_, err := addr.ValueForProtocol(ma.P_CIRCUIT)
+	return err == nil
+}
+
+func queryEventName(t routing.QueryEventType) string {
+	switch t {
+	case routing.SendingQuery:
+		return "sending-query"
+	case routing.PeerResponse:
+		return "peer-response"
+	case routing.FinalPeer:
+		return "final-peer"
+	case routing.QueryError:
+		return "query-error"
+	case routing.Provider:
+		return "provider"
+	case routing.Value:
+		return "value"
+	case routing.AddingPeer:
+		return "adding-peer"
+	case routing.DialingPeer:
+		return "dialing-peer"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,78 @@
+package go_ipfs_p2p
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType identifies the kind of Event
+type EventType string
+
+const (
+	// EventForwardFailed a forward could not be created or re-established
+	EventForwardFailed EventType = "forward.failed"
+)
+
+// Event is published by P2pClient on notable state changes
+type Event struct {
+	Type     EventType
+	Time     time.Time
+	PeerId   string
+	Protocol string
+	Err      error
+}
+
+// eventBus fans events out to subscribers without ever blocking the publisher
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[int]chan Event
+	next int
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{
+		subs: make(map[int]chan Event),
+	}
+}
+
+func (b *eventBus) subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+
+	b.mu.Lock()
+	id := b.next
+	b.next++
+	b.subs[id] = ch
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subs, id)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func (b *eventBus) emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			// slow subscriber, drop the event
+		}
+	}
+}
+
+// Subscribe returns a channel of client events and a function ending the subscription.
+// Events are dropped for subscribers whose buffer is full.
+func (c *P2pClient) Subscribe(buffer int) (<-chan Event, func()) {
+	return c.events.subscribe(buffer)
+}
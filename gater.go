@@ -0,0 +1,110 @@
+package go_ipfs_p2p
+
+import (
+	"github.com/libp2p/go-libp2p-core/control"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+	"sync"
+)
+
+// connGater decides which peers the host may connect to or accept connections from
+type connGater struct {
+	mu sync.RWMutex
+
+	// strict rejects every peer that is not in allowlist
+	strict    bool
+	allowlist map[peer.ID]struct{}
+}
+
+func newConnGater() *connGater {
+	return &connGater{
+		allowlist: make(map[peer.ID]struct{}),
+	}
+}
+
+func (g *connGater) allow(ids ...peer.ID) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, id := range ids {
+		g.allowlist[id] = struct{}{}
+	}
+}
+
+func (g *connGater) disallow(ids ...peer.ID) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, id := range ids {
+		delete(g.allowlist, id)
+	}
+}
+
+// permitted reports whether the host may interconnect with p
+func (g *connGater) permitted(p peer.ID) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	if g.strict {
+		if _, ok := g.allowlist[p]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *connGater) InterceptPeerDial(p peer.ID) bool {
+	return g.permitted(p)
+}
+
+func (g *connGater) InterceptAddrDial(p peer.ID, _ ma.Multiaddr) bool {
+	return g.permitted(p)
+}
+
+func (g *connGater) InterceptAccept(network.ConnMultiaddrs) bool {
+	return true
+}
+
+func (g *connGater) InterceptSecured(_ network.Direction, p peer.ID, _ network.ConnMultiaddrs) bool {
+	return g.permitted(p)
+}
+
+func (g *connGater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+// AllowPeer adds peers to the allowlist used in strict allowlist mode
+func (c *P2pClient) AllowPeer(peerIds ...string) error {
+	ids, err := decodePeerIds(peerIds)
+	if err != nil {
+		return err
+	}
+	c.cfg.gater.allow(ids...)
+	return nil
+}
+
+// DisallowPeer removes peers from the allowlist and, in strict mode, drops their connections
+func (c *P2pClient) DisallowPeer(peerIds ...string) error {
+	ids, err := decodePeerIds(peerIds)
+	if err != nil {
+		return err
+	}
+	c.cfg.gater.disallow(ids...)
+	for _, id := range ids {
+		if !c.cfg.gater.permitted(id) {
+			_ = c.Host.Network().ClosePeer(id)
+		}
+	}
+	return nil
+}
+
+func decodePeerIds(peerIds []string) ([]peer.ID, error) {
+	ids := make([]peer.ID, 0, len(peerIds))
+	for _, s := range peerIds {
+		id, err := peer.Decode(s)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,81 @@
+package go_ipfs_p2p
+
+import (
+	"errors"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	"sync"
+)
+
+// customListener is a mapping implemented by this package instead of go-ipfs' p2p,
+// used where the stream data path needs more control than ipfsp2p offers
+type customListener interface {
+	Protocol() protocol.ID
+	ListenAddress() ma.Multiaddr
+	TargetAddress() ma.Multiaddr
+	Close() error
+}
+
+// customListeners is the registry of package implemented listeners, keyed by listen address
+type customListeners struct {
+	sync.Mutex
+
+	Listeners map[string]customListener
+}
+
+func newCustomListeners() *customListeners {
+	return &customListeners{
+		Listeners: make(map[string]customListener),
+	}
+}
+
+func (r *customListeners) register(l customListener) error {
+	r.Lock()
+	defer r.Unlock()
+
+	key := l.ListenAddress().String()
+	if _, ok := r.Listeners[key]; ok {
+		return errors.New("listener already registered")
+	}
+	r.Listeners[key] = l
+	return nil
+}
+
+func (r *customListeners) deregister(l customListener) {
+	r.Lock()
+	defer r.Unlock()
+
+	key := l.ListenAddress().String()
+	if r.Listeners[key] == l {
+		delete(r.Listeners, key)
+	}
+}
+
+func (r *customListeners) list() []customListener {
+	r.Lock()
+	defer r.Unlock()
+
+	out := make([]customListener, 0, len(r.Listeners))
+	for _, l := range r.Listeners {
+		out = append(out, l)
+	}
+	return out
+}
+
+// close closes and removes every listener accepted by matchFunc
+func (r *customListeners) close(matchFunc func(l customListener) bool) int {
+	todo := make([]customListener, 0)
+	r.Lock()
+	for key, l := range r.Listeners {
+		if matchFunc(l) {
+			delete(r.Listeners, key)
+			todo = append(todo, l)
+		}
+	}
+	r.Unlock()
+
+	for _, l := range todo {
+		_ = l.Close()
+	}
+	return len(todo)
+}
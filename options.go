@@ -0,0 +1,45 @@
+package go_ipfs_p2p
+
+// Option configures a P2pClient at construction time
+type Option func(cfg *config) error
+
+// config collects the settings applied by Option values
+type config struct {
+	negotiationLimiter *negotiationLimiter
+	gater              *connGater
+}
+
+func newConfig(opts ...Option) (*config, error) {
+	cfg := &config{
+		gater: newConnGater(),
+	}
+	for _, opt := range opts {
+		if err := opt(cfg); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// WithNegotiationLimit rate limits inbound stream negotiations per peer and globally
+func WithNegotiationLimit(limit NegotiationLimit) Option {
+	return func(cfg *config) error {
+		cfg.negotiationLimiter = newNegotiationLimiter(limit)
+		return nil
+	}
+}
+
+// WithAllowlist enables strict mode: the host only connects to and accepts
+// connections from the given peers, on top of swarm key membership.
+// Bootstrap and relay peers must be listed too.
+func WithAllowlist(peerIds ...string) Option {
+	return func(cfg *config) error {
+		ids, err := decodePeerIds(peerIds)
+		if err != nil {
+			return err
+		}
+		cfg.gater.strict = true
+		cfg.gater.allow(ids...)
+		return nil
+	}
+}
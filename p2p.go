@@ -32,7 +32,7 @@ import (
 var resolveTimeout = 10 * time.Second
 
 // NewRoutedHost create a p2p routing client
-func newRoutedHost(listenPort int, privstr string, swarmkey []byte, peers []string) (host.Host, *rhost.RoutedHost, *dht.IpfsDHT, error) {
+func newRoutedHost(listenPort int, privstr string, swarmkey []byte, peers []string, cfg *config) (host.Host, *rhost.RoutedHost, *dht.IpfsDHT, error) {
 	ctx := context.Background()
 
 	skbytes, err := base64.StdEncoding.DecodeString(privstr)
@@ -64,6 +64,7 @@ func newRoutedHost(listenPort int, privstr string, swarmkey []byte, peers []stri
 		libp2p.DefaultSecurity,
 		libp2p.NATPortMap(),
 		libp2p.PrivateNetwork(psk),
+		libp2p.ConnectionGater(cfg.gater),
 		libp2p.ConnectionManager(connmgr.NewConnManager(
 			100,         // Lowwater
 			400,         // HighWater,
@@ -88,6 +89,11 @@ func newRoutedHost(listenPort int, privstr string, swarmkey []byte, peers []stri
 		return nil, nil, nil, err
 	}
 
+	if cfg.negotiationLimiter != nil && !installNegotiationLimiter(basicHost.Network(), cfg.negotiationLimiter) {
+		basicHost.Close()
+		return nil, nil, nil, errors.New("network does not support negotiation rate limiting")
+	}
+
 	// Construct a datastore (needed by the DHT). This is just a simple, in-memory thread-safe datastore.
 	dstore := dsync.MutexWrap(ds.NewMapDatastore())
 
@@ -97,13 +103,13 @@ func newRoutedHost(listenPort int, privstr string, swarmkey []byte, peers []stri
 	// Make the routed host
 	routedHost := rhost.Wrap(basicHost, DHT)
 
-	cfg := DefaultBootstrapConfig
-	cfg.BootstrapPeers = func() []peer.AddrInfo {
+	bootCfg := DefaultBootstrapConfig
+	bootCfg.BootstrapPeers = func() []peer.AddrInfo {
 		return bootstrapPeers
 	}
 
 	id, err := peer.IDFromPrivateKey(priv)
-	_, err = Bootstrap(id, routedHost, DHT, cfg)
+	_, err = Bootstrap(id, routedHost, DHT, bootCfg)
 
 	// connect to the chosen ipfs nodes
 	if err != nil {
@@ -143,21 +149,45 @@ type P2pClient struct {
 	DHT        *dht.IpfsDHT
 	RoutedHost *rhost.RoutedHost
 	Peers      []string
+
+	cfg     *config
+	events  *eventBus
+	custom  *customListeners
+	stripes *stripeSessions
 }
 
-func NewP2pClient(listenPort int, privstr string, swarmkey string, peers []string) (*P2pClient, error) {
-	host, routedHost, DHT, err := newRoutedHost(listenPort, privstr, []byte(swarmkey), peers)
+func NewP2pClient(listenPort int, privstr string, swarmkey string, peers []string, opts ...Option) (*P2pClient, error) {
+	cfg, err := newConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
+	host, routedHost, DHT, err := newRoutedHost(listenPort, privstr, []byte(swarmkey), peers, cfg)
 	if err != nil {
 		return nil, err
 	}
 	P2P := newIpfsP2p(host)
-	return &P2pClient{
+	c := &P2pClient{
 		Host:       host,
 		P2P:        P2P,
 		DHT:        DHT,
 		RoutedHost: routedHost,
 		Peers:      peers,
-	}, nil
+		cfg:        cfg,
+		events:     newEventBus(),
+		custom:     newCustomListeners(),
+		stripes:    newStripeSessions(),
+	}
+	host.SetStreamHandler(traceProtocol, c.handleTrace)
+	host.SetStreamHandler(stripeProtocol, c.handleStripe)
+	return c, nil
+}
+
+// NegotiationStats returns the inbound negotiation counters, or zero values when no limit is configured
+func (c *P2pClient) NegotiationStats() NegotiationStats {
+	if c.cfg.negotiationLimiter == nil {
+		return NegotiationStats{}
+	}
+	return c.cfg.negotiationLimiter.stats()
 }
 
 // P2PListenerInfoOutput  p2p monitoring or mapping information
@@ -196,6 +226,14 @@ func (c *P2pClient) List() *P2PLsOutput {
 	}
 	c.P2P.ListenersP2P.Unlock()
 
+	for _, listener := range c.custom.list() {
+		output.Listeners = append(output.Listeners, P2PListenerInfoOutput{
+			Protocol:      string(listener.Protocol()),
+			ListenAddress: listener.ListenAddress().String(),
+			TargetAddress: listener.TargetAddress().String(),
+		})
+	}
+
 	return output
 }
 
@@ -339,6 +377,9 @@ func (c *P2pClient) Close(target string) (int, error) {
 
 	done := c.P2P.ListenersLocal.Close(match)
 	done += c.P2P.ListenersP2P.Close(match)
+	done += c.custom.close(func(listener customListener) bool {
+		return targetAddress.Equal(listener.TargetAddress())
+	})
 
 	return done, nil
 
@@ -354,6 +395,9 @@ func (c *P2pClient) Destroy() error {
 	}
 	c.P2P.ListenersP2P.Close(match)
 	c.P2P.ListenersLocal.Close(match)
+	c.custom.close(func(listener customListener) bool {
+		return true
+	})
 	err := (c.Host).Close()
 	c.P2P = nil
 	c.Host = nil
@@ -415,13 +459,82 @@ func parseIpfsAddr(addr string) (*peer.AddrInfo, error) {
 	return &info, nil
 }
 
+// ForwardState tells how ForwardRandomPort obtained its mapping
+type ForwardState int
+
+const (
+	// ForwardFresh a new mapping was created
+	ForwardFresh ForwardState = iota
+	// ForwardReused an existing mapping passed the health check and was returned as is
+	ForwardReused
+	// ForwardRecreated an existing mapping was dead and has been re-established on the same port
+	ForwardRecreated
+)
+
+func (s ForwardState) String() string {
+	switch s {
+	case ForwardFresh:
+		return "fresh"
+	case ForwardReused:
+		return "reused"
+	case ForwardRecreated:
+		return "recreated"
+	default:
+		return "unknown"
+	}
+}
+
+// ForwardError is returned by ForwardRandomPort and tells which step failed
+type ForwardError struct {
+	Op       string
+	PeerId   string
+	Protocol string
+	Err      error
+}
+
+func (e *ForwardError) Error() string {
+	return fmt.Sprintf("forward to %s (%s) failed at %s: %s", e.PeerId, e.Protocol, e.Op, e.Err)
+}
+
+func (e *ForwardError) Unwrap() error {
+	return e.Err
+}
+
+// forwardFailed wraps err into a ForwardError and publishes an EventForwardFailed
+func (s *P2pClient) forwardFailed(op, peerId, proto string, err error) error {
+	ferr := &ForwardError{Op: op, PeerId: peerId, Protocol: proto, Err: err}
+	s.events.emit(Event{Type: EventForwardFailed, PeerId: peerId, Protocol: proto, Err: ferr})
+	return ferr
+}
+
+// RandomPortForward local endpoint of a mapping returned by ForwardRandomPort
+type RandomPortForward struct {
+	IP       string
+	Port     string
+	Protocol string
+	State    ForwardState
+}
+
+// ForwardWithRandomPort map the remote node to a random local port and return its ip and port
+//
+// Deprecated: use ForwardRandomPort, which returns a *ForwardError and the reuse state.
 func (s *P2pClient) ForwardWithRandomPort(peerId string) (string, string, error) {
-	list, err := s.ListListen()
+	f, err := s.ForwardRandomPort(peerId)
 	if err != nil {
-		fmt.Println("创建容器部署指令失败")
-		fmt.Println("查询p2p 列表失败")
 		return "", "", err
 	}
+	return f.IP, f.Port, nil
+}
+
+// ForwardRandomPort map the remote node to a random local port. An existing mapping
+// to the same node is health checked first and re-established if its stream path is dead.
+func (s *P2pClient) ForwardRandomPort(peerId string) (*RandomPortForward, error) {
+	proto := "/x/ssh"
+
+	list, err := s.ListListen()
+	if err != nil {
+		return nil, s.forwardFailed("list", peerId, proto, err)
+	}
 
 	t, find := lo.Find(list, func(item *ListenReply) bool {
 		if item == nil {
@@ -446,28 +559,36 @@ func (s *P2pClient) ForwardWithRandomPort(peerId string) (string, string, error)
 
 			fmt.Printf("IP地址: %s\n", ip)
 			fmt.Printf("端口号: %s\n", port)
-			return ip, port, nil
-		} else {
-			fmt.Println("无法提取IP地址和端口号")
+
+			if err := s.CheckForwardHealth(t.Protocol, peerId); err == nil {
+				return &RandomPortForward{IP: ip, Port: port, Protocol: t.Protocol, State: ForwardReused}, nil
+			}
+
+			// the stream path is dead, drop the stale listener and map the same port again
+			fmt.Println("existing mapping " + listenAddress + " is unhealthy, recreating")
+			s.P2P.ListenersLocal.Close(func(listener ipfsp2p.Listener) bool {
+				return listener.ListenAddress().String() == listenAddress
+			})
+			listenPort, err := strconv.Atoi(port)
+			if err != nil {
+				return nil, s.forwardFailed("parse", peerId, t.Protocol, err)
+			}
+			if err := s.Forward(t.Protocol, listenPort, peerId); err != nil {
+				return nil, s.forwardFailed("recreate", peerId, t.Protocol, err)
+			}
+			return &RandomPortForward{IP: ip, Port: port, Protocol: t.Protocol, State: ForwardRecreated}, nil
 		}
+		return nil, s.forwardFailed("parse", peerId, t.Protocol, fmt.Errorf("cannot extract ip and port from %s", listenAddress))
 	}
 
 	listenIp := "127.0.0.1"
 	listenPort := rand.Intn(9999) + 30000
 
-	if err != nil {
-		return "", "", err
-	}
-	proto := "/x/ssh"
-
 	err = s.Forward(proto, listenPort, peerId)
 	if err != nil {
-		fmt.Println("创建容器部署指令失败")
-		fmt.Println(err)
-		return "", "", err
+		return nil, s.forwardFailed("forward", peerId, proto, err)
 	}
-	return listenIp, strconv.Itoa(listenPort), err
-
+	return &RandomPortForward{IP: listenIp, Port: strconv.Itoa(listenPort), Protocol: proto, State: ForwardFresh}, nil
 }
 
 func (s *P2pClient) ListListen() ([]*ListenReply, error) {
@@ -492,6 +613,14 @@ func (s *P2pClient) ListListen() ([]*ListenReply, error) {
 		})
 	}
 	s.P2P.ListenersP2P.Unlock()
+
+	for _, listener := range s.custom.list() {
+		output = append(output, &ListenReply{
+			Protocol:      string(listener.Protocol()),
+			ListenAddress: listener.ListenAddress().String(),
+			TargetAddress: listener.TargetAddress().String(),
+		})
+	}
 	return output, nil
 }
 
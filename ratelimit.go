@@ -0,0 +1,175 @@
+package go_ipfs_p2p
+
+import (
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxTrackedPeers bounds the per-peer bucket map before idle entries are pruned
+const maxTrackedPeers = 1024
+
+// NegotiationLimit describes how many inbound stream negotiations are allowed.
+// A zero rate disables the corresponding limit.
+type NegotiationLimit struct {
+	// PerPeerRate is the number of negotiations per second allowed for a single remote peer
+	PerPeerRate float64
+	// PerPeerBurst is the number of negotiations a single peer may start at once
+	PerPeerBurst int
+	// GlobalRate is the number of negotiations per second allowed across all peers
+	GlobalRate float64
+	// GlobalBurst is the number of negotiations that may start at once across all peers
+	GlobalBurst int
+}
+
+// NegotiationStats counts inbound stream negotiations seen by the limiter
+type NegotiationStats struct {
+	Accepted       uint64
+	RejectedPeer   uint64
+	RejectedGlobal uint64
+}
+
+// tokenBucket is a minimal token bucket, callers are responsible for locking
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+}
+
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+type negotiationLimiter struct {
+	limit NegotiationLimit
+
+	mu     sync.Mutex
+	global *tokenBucket
+	peers  map[peer.ID]*tokenBucket
+
+	accepted       uint64
+	rejectedPeer   uint64
+	rejectedGlobal uint64
+}
+
+func newNegotiationLimiter(limit NegotiationLimit) *negotiationLimiter {
+	l := &negotiationLimiter{
+		limit: limit,
+		peers: make(map[peer.ID]*tokenBucket),
+	}
+	if limit.GlobalRate > 0 {
+		l.global = newTokenBucket(limit.GlobalRate, limit.GlobalBurst)
+	}
+	return l
+}
+
+// allow reports whether p may start another negotiation, consuming a token if so
+func (l *negotiationLimiter) allow(p peer.ID) bool {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limit.PerPeerRate > 0 {
+		b, ok := l.peers[p]
+		if !ok {
+			if len(l.peers) >= maxTrackedPeers {
+				l.prune(now)
+			}
+			b = newTokenBucket(l.limit.PerPeerRate, l.limit.PerPeerBurst)
+			l.peers[p] = b
+		}
+		if !b.allow(now) {
+			atomic.AddUint64(&l.rejectedPeer, 1)
+			return false
+		}
+	}
+
+	if l.global != nil && !l.global.allow(now) {
+		atomic.AddUint64(&l.rejectedGlobal, 1)
+		return false
+	}
+
+	atomic.AddUint64(&l.accepted, 1)
+	return true
+}
+
+// prune drops buckets of peers that have been idle long enough to refill completely
+func (l *negotiationLimiter) prune(now time.Time) {
+	for p, b := range l.peers {
+		if b.full(now) {
+			delete(l.peers, p)
+		}
+	}
+}
+
+func (l *negotiationLimiter) stats() NegotiationStats {
+	return NegotiationStats{
+		Accepted:       atomic.LoadUint64(&l.accepted),
+		RejectedPeer:   atomic.LoadUint64(&l.rejectedPeer),
+		RejectedGlobal: atomic.LoadUint64(&l.rejectedGlobal),
+	}
+}
+
+// streamHandlerNetwork is implemented by the swarm, which exposes the handler installed by the host
+type streamHandlerNetwork interface {
+	StreamHandler() network.StreamHandler
+	SetStreamHandler(network.StreamHandler)
+}
+
+// installNegotiationLimiter wraps the network's inbound stream handler so that
+// streams over the limit are reset before protocol negotiation starts
+func installNegotiationLimiter(n network.Network, l *negotiationLimiter) bool {
+	sn, ok := n.(streamHandlerNetwork)
+	if !ok {
+		return false
+	}
+	next := sn.StreamHandler()
+	sn.SetStreamHandler(func(s network.Stream) {
+		p := s.Conn().RemotePeer()
+		if !l.allow(p) {
+			logrus.Debugf("inbound stream negotiation from %s rejected by rate limit", p)
+			_ = s.Reset()
+			return
+		}
+		next(s)
+	})
+	return true
+}
@@ -0,0 +1,28 @@
+package go_ipfs_p2p
+
+import (
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNegotiationLimiter(t *testing.T) {
+	l := newNegotiationLimiter(NegotiationLimit{
+		PerPeerRate:  0.001,
+		PerPeerBurst: 2,
+		GlobalRate:   0.001,
+		GlobalBurst:  3,
+	})
+
+	a, b := peer.ID("a"), peer.ID("b")
+	assert.True(t, l.allow(a))
+	assert.True(t, l.allow(a))
+	assert.False(t, l.allow(a))
+	assert.True(t, l.allow(b))
+	assert.False(t, l.allow(b))
+
+	stats := l.stats()
+	assert.Equal(t, uint64(3), stats.Accepted)
+	assert.Equal(t, uint64(1), stats.RejectedPeer)
+	assert.Equal(t, uint64(1), stats.RejectedGlobal)
+}
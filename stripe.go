@@ -0,0 +1,369 @@
+package go_ipfs_p2p
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	tec "github.com/jbenet/go-temp-err-catcher"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/sirupsen/logrus"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// stripeProtocol carries one local connection striped over several parallel streams
+const stripeProtocol = protocol.ID("/go-ipfs-p2p/stripe/1.0.0")
+
+const (
+	maxStripeStreams = 16
+	stripeFrameSize  = 32 * 1024
+	maxStripeProto   = 1024
+)
+
+// stripeSessionTimeout bounds how long the serving side waits for all streams of a session
+var stripeSessionTimeout = 30 * time.Second
+
+// stripeHeader opens every stream of a striped session
+type stripeHeader struct {
+	Session uint64
+	Index   uint8
+	Count   uint8
+	Proto   string
+}
+
+func (h stripeHeader) write(w io.Writer) error {
+	buf := make([]byte, 12+len(h.Proto))
+	binary.BigEndian.PutUint64(buf, h.Session)
+	buf[8] = h.Index
+	buf[9] = h.Count
+	binary.BigEndian.PutUint16(buf[10:], uint16(len(h.Proto)))
+	copy(buf[12:], h.Proto)
+	_, err := w.Write(buf)
+	return err
+}
+
+func readStripeHeader(r io.Reader) (stripeHeader, error) {
+	var h stripeHeader
+	buf := make([]byte, 12)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return h, err
+	}
+	h.Session = binary.BigEndian.Uint64(buf)
+	h.Index = buf[8]
+	h.Count = buf[9]
+	n := binary.BigEndian.Uint16(buf[10:])
+	if n > maxStripeProto {
+		return h, errors.New("stripe protocol id too long")
+	}
+	proto := make([]byte, n)
+	if _, err := io.ReadFull(r, proto); err != nil {
+		return h, err
+	}
+	h.Proto = string(proto)
+	if h.Count == 0 || h.Count > maxStripeStreams || h.Index >= h.Count {
+		return h, fmt.Errorf("invalid stripe %d/%d", h.Index, h.Count)
+	}
+	return h, nil
+}
+
+// ForwardStriped map a remote node's service to a local port like Forward, carrying each
+// local connection over several parallel streams to work around per-stream throughput limits.
+// The remote node must run this package and Listen on proto.
+func (c *P2pClient) ForwardStriped(proto string, port int, peerId string, streams int) error {
+	if peerId == "" {
+		return fmt.Errorf("peer id cannot be empty")
+	}
+	if streams < 1 || streams > maxStripeStreams {
+		return fmt.Errorf("stream count must be between 1 and %d", maxStripeStreams)
+	}
+	id, err := peer.Decode(peerId)
+	if err != nil {
+		return err
+	}
+	listen, err := ma.NewMultiaddr(fmt.Sprintf("/ip4/127.0.0.1/tcp/%d", port))
+	if err != nil {
+		return err
+	}
+	ml, err := manet.Listen(listen)
+	if err != nil {
+		return err
+	}
+
+	l := &stripedListener{
+		c:        c,
+		proto:    protocol.ID(proto),
+		peer:     id,
+		streams:  streams,
+		listener: ml,
+	}
+	if err := c.custom.register(l); err != nil {
+		_ = ml.Close()
+		return err
+	}
+	go l.acceptConns()
+	return nil
+}
+
+// stripedListener accepts local connections and stripes them over parallel streams
+type stripedListener struct {
+	c *P2pClient
+
+	proto   protocol.ID
+	peer    peer.ID
+	streams int
+
+	listener manet.Listener
+}
+
+func (l *stripedListener) Protocol() protocol.ID {
+	return l.proto
+}
+
+func (l *stripedListener) ListenAddress() ma.Multiaddr {
+	return l.listener.Multiaddr()
+}
+
+func (l *stripedListener) TargetAddress() ma.Multiaddr {
+	return ma.StringCast("/p2p/" + l.peer.Pretty())
+}
+
+func (l *stripedListener) Close() error {
+	l.c.custom.deregister(l)
+	return l.listener.Close()
+}
+
+func (l *stripedListener) acceptConns() {
+	for {
+		local, err := l.listener.Accept()
+		if err != nil {
+			if tec.ErrIsTemporary(err) {
+				continue
+			}
+			return
+		}
+		go l.setupSession(local)
+	}
+}
+
+func (l *stripedListener) setupSession(local manet.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	header := stripeHeader{
+		Session: rand.Uint64(),
+		Count:   uint8(l.streams),
+		Proto:   string(l.proto),
+	}
+	streams := make([]network.Stream, 0, l.streams)
+	for i := 0; i < l.streams; i++ {
+		s, err := l.c.Host.NewStream(ctx, l.peer, stripeProtocol)
+		if err == nil {
+			header.Index = uint8(i)
+			if err = header.write(s); err != nil {
+				_ = s.Reset()
+			}
+		}
+		if err != nil {
+			logrus.Warnf("failed to open stripe %d to %s/%s: %s", i, l.peer, l.proto, err)
+			for _, s := range streams {
+				_ = s.Reset()
+			}
+			_ = local.Close()
+			return
+		}
+		streams = append(streams, s)
+	}
+
+	pipeStriped(local, streams)
+}
+
+type stripeKey struct {
+	peer    peer.ID
+	session uint64
+}
+
+type pendingStripe struct {
+	streams  []network.Stream
+	received int
+	timer    *time.Timer
+}
+
+// stripeSessions collects the streams of striped sessions until all of them arrived
+type stripeSessions struct {
+	mu      sync.Mutex
+	pending map[stripeKey]*pendingStripe
+}
+
+func newStripeSessions() *stripeSessions {
+	return &stripeSessions{
+		pending: make(map[stripeKey]*pendingStripe),
+	}
+}
+
+// add records s as stripe h.Index of its session and returns all streams once the session is complete
+func (r *stripeSessions) add(h stripeHeader, s network.Stream) ([]network.Stream, error) {
+	key := stripeKey{peer: s.Conn().RemotePeer(), session: h.Session}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p, ok := r.pending[key]
+	if !ok {
+		p = &pendingStripe{streams: make([]network.Stream, h.Count)}
+		p.timer = time.AfterFunc(stripeSessionTimeout, func() {
+			r.expire(key, p)
+		})
+		r.pending[key] = p
+	}
+	if len(p.streams) != int(h.Count) || p.streams[h.Index] != nil {
+		return nil, errors.New("inconsistent stripe session")
+	}
+	p.streams[h.Index] = s
+	p.received++
+	if p.received < len(p.streams) {
+		return nil, nil
+	}
+	p.timer.Stop()
+	delete(r.pending, key)
+	return p.streams, nil
+}
+
+func (r *stripeSessions) expire(key stripeKey, p *pendingStripe) {
+	r.mu.Lock()
+	if r.pending[key] != p {
+		r.mu.Unlock()
+		return
+	}
+	delete(r.pending, key)
+	r.mu.Unlock()
+
+	for _, s := range p.streams {
+		if s != nil {
+			_ = s.Reset()
+		}
+	}
+}
+
+// handleStripe serves the remote side of ForwardStriped for protocols exposed through Listen
+func (c *P2pClient) handleStripe(s network.Stream) {
+	_ = s.SetReadDeadline(time.Now().Add(stripeSessionTimeout))
+	h, err := readStripeHeader(s)
+	if err != nil {
+		_ = s.Reset()
+		return
+	}
+	_ = s.SetReadDeadline(time.Time{})
+
+	streams, err := c.stripes.add(h, s)
+	if err != nil {
+		_ = s.Reset()
+		return
+	}
+	if streams == nil {
+		return
+	}
+
+	resetAll := func() {
+		for _, s := range streams {
+			_ = s.Reset()
+		}
+	}
+
+	c.P2P.ListenersP2P.RLock()
+	listener := c.P2P.ListenersP2P.Listeners[h.Proto]
+	c.P2P.ListenersP2P.RUnlock()
+	if listener == nil {
+		resetAll()
+		return
+	}
+	local, err := manet.Dial(listener.TargetAddress())
+	if err != nil {
+		resetAll()
+		return
+	}
+
+	pipeStriped(local, streams)
+}
+
+// pipeStriped copies conn to streams round robin in length prefixed frames and
+// reassembles the frames coming back in the same order. Like ipfsp2p streams,
+// everything is torn down once either direction ends.
+func pipeStriped(conn net.Conn, streams []network.Stream) {
+	var once sync.Once
+	done := make(chan struct{})
+	teardown := func(err error) {
+		once.Do(func() {
+			_ = conn.Close()
+			for _, s := range streams {
+				if err != nil {
+					_ = s.Reset()
+				} else {
+					_ = s.Close()
+				}
+			}
+			close(done)
+		})
+	}
+
+	go func() {
+		teardown(splitStripes(conn, streams))
+	}()
+	go func() {
+		teardown(mergeStripes(streams, conn))
+	}()
+	<-done
+}
+
+func splitStripes(src io.Reader, streams []network.Stream) error {
+	buf := make([]byte, 4+stripeFrameSize)
+	for i := 0; ; i++ {
+		n, err := src.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, werr := streams[i%len(streams)].Write(buf[:4+n]); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			for _, s := range streams {
+				_ = s.CloseWrite()
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func mergeStripes(streams []network.Stream, dst io.Writer) error {
+	lenBuf := make([]byte, 4)
+	buf := make([]byte, stripeFrameSize)
+	for i := 0; ; i++ {
+		s := streams[i%len(streams)]
+		if _, err := io.ReadFull(s, lenBuf); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		n := binary.BigEndian.Uint32(lenBuf)
+		if n > stripeFrameSize {
+			return errors.New("stripe frame too large")
+		}
+		if _, err := io.ReadFull(s, buf[:n]); err != nil {
+			return err
+		}
+		if _, err := dst.Write(buf[:n]); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package go_ipfs_p2p
+
+import (
+	"bytes"
+	"context"
+	"github.com/libp2p/go-libp2p-core/network"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestStripeRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	mn, err := mocknet.FullMeshConnected(ctx, 2)
+	require.NoError(t, err)
+	a, b := mn.Hosts()[0], mn.Hosts()[1]
+
+	const count = 3
+	sessions := newStripeSessions()
+	received := make(chan []byte, 1)
+	b.SetStreamHandler(stripeProtocol, func(s network.Stream) {
+		h, err := readStripeHeader(s)
+		require.NoError(t, err)
+		streams, err := sessions.add(h, s)
+		require.NoError(t, err)
+		if streams == nil {
+			return
+		}
+		var buf bytes.Buffer
+		require.NoError(t, mergeStripes(streams, &buf))
+		received <- buf.Bytes()
+	})
+
+	streams := make([]network.Stream, count)
+	for i := range streams {
+		s, err := a.NewStream(ctx, b.ID(), stripeProtocol)
+		require.NoError(t, err)
+		require.NoError(t, stripeHeader{Session: 42, Index: uint8(i), Count: count, Proto: "/x/test"}.write(s))
+		streams[i] = s
+	}
+
+	payload := make([]byte, 5*stripeFrameSize+123)
+	rand.Read(payload)
+	require.NoError(t, splitStripes(ioutil.NopCloser(bytes.NewReader(payload)), streams))
+	assert.Equal(t, payload, <-received)
+}
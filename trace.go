@@ -0,0 +1,150 @@
+package go_ipfs_p2p
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// traceProtocol lets a peer report how it sees the connection a trace arrived on
+const traceProtocol = protocol.ID("/go-ipfs-p2p/trace/1.0.0")
+
+// TraceHop is one overlay hop on the way to the traced peer
+type TraceHop struct {
+	PeerId    string
+	Addr      string
+	Transport string
+	RTT       time.Duration
+	Error     string
+}
+
+// TraceRemoteView is the connection as observed by the traced peer
+type TraceRemoteView struct {
+	ObservedAddr string
+	Transport    string
+	Relayed      bool
+}
+
+// TraceRoute is the path a stream to a peer actually takes
+type TraceRoute struct {
+	PeerId string
+	Direct bool
+	// Relay is the relay peer carrying the circuit, empty for direct connections
+	Relay      string
+	Hops       []TraceHop
+	RemoteView *TraceRemoteView
+	RemoteErr  string
+}
+
+// TraceRoute opens a stream to the peer and reports the hops it traverses,
+// the transport of each hop, their round trip times and the remote side's view
+func (c *P2pClient) TraceRoute(ctx context.Context, peerId string) (*TraceRoute, error) {
+	id, err := peer.Decode(peerId)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.RoutedHost.Connect(ctx, peer.AddrInfo{ID: id}); err != nil {
+		return nil, err
+	}
+
+	route := &TraceRoute{PeerId: peerId}
+
+	var conn network.Conn
+	s, err := c.Host.NewStream(ctx, id, traceProtocol)
+	if err != nil {
+		route.RemoteErr = err.Error()
+		conns := c.Host.Network().ConnsToPeer(id)
+		if len(conns) == 0 {
+			return nil, errors.New("no connection to peer")
+		}
+		conn = conns[0]
+	} else {
+		conn = s.Conn()
+		view := &TraceRemoteView{}
+		_ = s.SetReadDeadline(time.Now().Add(resolveTimeout))
+		if err := json.NewDecoder(s).Decode(view); err != nil {
+			route.RemoteErr = err.Error()
+		} else {
+			route.RemoteView = view
+		}
+		_ = s.Close()
+	}
+
+	addr := conn.RemoteMultiaddr()
+	if relay, ok := circuitRelay(addr); ok {
+		route.Relay = relay.Pretty()
+		relayHop := TraceHop{PeerId: relay.Pretty()}
+		if relayConns := c.Host.Network().ConnsToPeer(relay); len(relayConns) > 0 {
+			relayHop.Addr = relayConns[0].RemoteMultiaddr().String()
+			relayHop.Transport = transportName(relayConns[0].RemoteMultiaddr())
+		}
+		c.pingHop(ctx, relay, &relayHop)
+		route.Hops = append(route.Hops, relayHop)
+	} else {
+		route.Direct = true
+	}
+
+	targetHop := TraceHop{
+		PeerId:    peerId,
+		Addr:      addr.String(),
+		Transport: transportName(addr),
+	}
+	c.pingHop(ctx, id, &targetHop)
+	route.Hops = append(route.Hops, targetHop)
+
+	return route, nil
+}
+
+func (c *P2pClient) pingHop(ctx context.Context, id peer.ID, hop *TraceHop) {
+	pctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	res, ok := <-ping.Ping(pctx, c.Host, id)
+	switch {
+	case !ok:
+		hop.Error = "ping cancelled"
+	case res.Error != nil:
+		hop.Error = res.Error.Error()
+	default:
+		hop.RTT = res.RTT
+	}
+}
+
+// handleTrace answers a trace stream with the connection as seen from this side
+func (c *P2pClient) handleTrace(s network.Stream) {
+	defer s.Close()
+	addr := s.Conn().RemoteMultiaddr()
+	view := TraceRemoteView{
+		ObservedAddr: addr.String(),
+		Transport:    transportName(addr),
+		Relayed:      isRelayAddr(addr),
+	}
+	if err := json.NewEncoder(s).Encode(view); err != nil {
+		logrus.Debugf("trace reply to %s failed: %s", s.Conn().RemotePeer(), err)
+	}
+}
+
+// circuitRelay returns the relay peer of a /p2p/<relay>/p2p-circuit address
+func circuitRelay(addr ma.Multiaddr) (peer.ID, bool) {
+	var relay peer.ID
+	found := false
+	ma.ForEach(addr, func(c ma.Component) bool {
+		switch c.Protocol().Code {
+		case ma.P_P2P:
+			if id, err := peer.IDFromBytes(c.RawValue()); err == nil {
+				relay = id
+			}
+		case ma.P_CIRCUIT:
+			found = relay != ""
+			return false
+		}
+		return true
+	})
+	return relay, found
+}
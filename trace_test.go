@@ -0,0 +1,24 @@
+package go_ipfs_p2p
+
+import (
+	ma "github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCircuitRelay(t *testing.T) {
+	const relay = "12D3KooWRsKNAgbGaQkVbbzg5xEw2FtvPRF7MiYtmRvFPYegNVnu"
+
+	addr := ma.StringCast("/ip4/34.139.126.73/tcp/4001/p2p/" + relay + "/p2p-circuit")
+	id, ok := circuitRelay(addr)
+	assert.True(t, ok)
+	assert.Equal(t, relay, id.Pretty())
+	assert.True(t, isRelayAddr(addr))
+	assert.Equal(t, "tcp/p2p-circuit", transportName(addr))
+
+	direct := ma.StringCast("/ip4/34.139.126.73/tcp/4001/ws")
+	_, ok = circuitRelay(direct)
+	assert.False(t, ok)
+	assert.False(t, isRelayAddr(direct))
+	assert.Equal(t, "tcp/ws", transportName(direct))
+}